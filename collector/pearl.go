@@ -19,15 +19,26 @@ import (
 	"bufio"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/fsnotify/fsnotify"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/soundcloud/go-runit/runit"
+	"gopkg.in/yaml.v2"
 
 	"github.com/go-kit/log"
 )
 
+var (
+	pearlPerThread  = kingpin.Flag("collector.pearl.per-thread", "Collect per-thread CPU and context switch metrics for pearl services from /proc/<pid>/task").Default("false").Bool()
+	pearlConfigPath = kingpin.Flag("collector.pearl.config", "Path to a YAML file declaring additional per-service pearl metrics").Default("").String()
+)
+
 var (
 	knownMetrics = []struct {
 		StatusKey      string
@@ -37,47 +48,571 @@ var (
 		Multiplier     int
 	}{
 		{"VmRSS", "service_mem_vmrss", "Virtual memory resident set size in bytes", 2, 1024},
+		{"VmSize", "service_mem_vmsize", "Virtual memory size in bytes", 2, 1024},
+		{"VmPeak", "service_mem_vmpeak", "Peak virtual memory size in bytes", 2, 1024},
+		{"VmData", "service_mem_vmdata", "Size of data segment in bytes", 2, 1024},
+		{"VmStk", "service_mem_vmstk", "Size of stack segment in bytes", 2, 1024},
+		{"RssAnon", "service_mem_rssanon", "Size of resident anonymous memory in bytes", 2, 1024},
+		{"RssFile", "service_mem_rssfile", "Size of resident file mappings in bytes", 2, 1024},
+		{"FDSize", "service_fdsize", "Size of the file descriptor table", 1, 1},
 		{"Threads", "service_threads", "Number of threads", 1, 1},
-		{"voluntary_ctxt_switches", "service_voluntary_ctxt_switches", "Number of voluntary context switches", 1, 1},
-		{"nonvoluntary_ctxt_switches", "service_nonvoluntary_ctxt_switches", "Number of nonvoluntary context switches", 1, 1},
 	}
+
+	// ctxtSwitchMetrics are the /proc/<pid>/status fields that are
+	// monotonic for the lifetime of a process, and are therefore exposed
+	// as counters (rather than through the generic knownMetrics gauge
+	// loop) so Prometheus can compute rate()/increase() over them.
+	ctxtSwitchMetrics = []struct {
+		StatusKey      string
+		MetricName     string
+		Description    string
+		NumberOfParams int
+	}{
+		{"voluntary_ctxt_switches", "service_voluntary_ctxt_switches", "Number of voluntary context switches", 1},
+		{"nonvoluntary_ctxt_switches", "service_nonvoluntary_ctxt_switches", "Number of nonvoluntary context switches", 1},
+	}
+)
+
+// clkTckHZ is the kernel clock tick rate (USER_HZ) used to convert the
+// utime/stime/starttime fields of /proc/<pid>/stat into seconds. This is
+// 100 on every Linux platform node_exporter supports.
+const clkTckHZ = 100
+
+var (
+	serviceUpDesc = prometheus.NewDesc(
+		"service_up",
+		"1 if the service's supervised process is normally up and running, 0 otherwise",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceStateDesc = prometheus.NewDesc(
+		"service_state",
+		"State of the supervised process (0 = down, 1 = run, 2 = finish)",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceUptimeSecondsDesc = prometheus.NewDesc(
+		"service_uptime_seconds",
+		"Time in seconds since the service entered its current state",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceWantStateDesc = prometheus.NewDesc(
+		"service_want_state",
+		"Desired state of the service as set by runsvctrl (0 = down, 1 = up)",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceRestartCountDesc = prometheus.NewDesc(
+		"service_restart_count",
+		"Number of times the service's PID has changed since node_exporter started",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceCPUSecondsTotalDesc = prometheus.NewDesc(
+		"service_cpu_seconds_total",
+		"Total user and system CPU time spent by the service's process in seconds",
+		[]string{"service", "channel_id", "mode"}, nil,
+	)
+	serviceOpenFDsDesc = prometheus.NewDesc(
+		"service_open_fds",
+		"Number of open file descriptors held by the service's process",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceMaxFDsDesc = prometheus.NewDesc(
+		"service_max_fds",
+		"Maximum number of open file descriptors allowed for the service's process, or -1 if unlimited",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceStartTimeSecondsDesc = prometheus.NewDesc(
+		"service_start_time_seconds",
+		"Start time of the service's process since unix epoch in seconds",
+		[]string{"service", "channel_id"}, nil,
+	)
+	serviceThreadCPUSecondsTotalDesc = prometheus.NewDesc(
+		"service_thread_cpu_seconds_total",
+		"Total user and system CPU time spent by an individual service thread in seconds",
+		[]string{"service", "channel_id", "tid", "name", "mode"}, nil,
+	)
+	serviceThreadCtxtSwitchesDesc = prometheus.NewDesc(
+		"service_thread_ctxt_switches",
+		"Number of context switches made by an individual service thread",
+		[]string{"service", "channel_id", "tid", "name", "type"}, nil,
+	)
+	serviceThreadCtxtSwitchesSumDesc = prometheus.NewDesc(
+		"service_thread_ctxt_switches_sum",
+		"Number of context switches summed across all threads of the service, from /proc/<pid>/task",
+		[]string{"service", "channel_id", "type"}, nil,
+	)
 )
 
+// pearlMetricConfig is one entry of the "metrics" list in a
+// --collector.pearl.config file: it declares an additional /proc/<pid>/status
+// field to expose as a metric, without requiring a rebuild.
+type pearlMetricConfig struct {
+	StatusKey      string            `yaml:"status_key"`
+	MetricName     string            `yaml:"metric_name"`
+	Help           string            `yaml:"help"`
+	Type           string            `yaml:"type"`
+	Multiplier     int               `yaml:"multiplier"`
+	LabelsFromFile map[string]string `yaml:"labels_from_file"`
+}
+
+// pearlExtraFile is one entry of the "extra_files" list: a file inside the
+// service directory whose whole trimmed contents become either a label
+// value (Label) or a metric value (MetricName). The contents are not parsed
+// as key/value pairs.
+type pearlExtraFile struct {
+	File       string `yaml:"file"`
+	Label      string `yaml:"label"`
+	MetricName string `yaml:"metric_name"`
+	Help       string `yaml:"help"`
+	Type       string `yaml:"type"`
+}
+
+type pearlConfig struct {
+	Metrics    []pearlMetricConfig `yaml:"metrics"`
+	ExtraFiles []pearlExtraFile    `yaml:"extra_files"`
+}
+
+func loadPearlConfig(path string) (*pearlConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg pearlConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	for i, m := range cfg.Metrics {
+		if m.StatusKey == "" || m.MetricName == "" {
+			return nil, fmt.Errorf("metrics[%d]: status_key and metric_name are required", i)
+		}
+		if m.Type != "" && m.Type != "gauge" && m.Type != "counter" {
+			return nil, fmt.Errorf("metrics[%d] (%s): type must be \"gauge\" or \"counter\", got %q", i, m.MetricName, m.Type)
+		}
+		if m.Multiplier == 0 {
+			cfg.Metrics[i].Multiplier = 1
+		}
+	}
+
+	for i, ef := range cfg.ExtraFiles {
+		if ef.File == "" {
+			return nil, fmt.Errorf("extra_files[%d]: file is required", i)
+		}
+		if (ef.Label == "") == (ef.MetricName == "") {
+			return nil, fmt.Errorf("extra_files[%d] (%s): exactly one of label or metric_name must be set", i, ef.File)
+		}
+		if ef.MetricName != "" && ef.Type != "" && ef.Type != "gauge" && ef.Type != "counter" {
+			return nil, fmt.Errorf("extra_files[%d] (%s): type must be \"gauge\" or \"counter\", got %q", i, ef.File, ef.Type)
+		}
+	}
+
+	return &cfg, nil
+}
+
+func valueTypeFor(configType string) prometheus.ValueType {
+	if configType == "counter" {
+		return prometheus.CounterValue
+	}
+	return prometheus.GaugeValue
+}
+
+// labelFileSource pairs a label name with the service-relative file its
+// value is read from at scrape time.
+type labelFileSource struct {
+	Label string
+	File  string
+}
+
+func sortedLabelFileSources(labelsFromFile map[string]string) []labelFileSource {
+	srcs := make([]labelFileSource, 0, len(labelsFromFile))
+	for label, file := range labelsFromFile {
+		srcs = append(srcs, labelFileSource{Label: label, File: file})
+	}
+	sort.Slice(srcs, func(i, j int) bool { return srcs[i].Label < srcs[j].Label })
+
+	return srcs
+}
+
+// configuredMetric is a pearlMetricConfig with its descriptor and label
+// order resolved up front, so Update never has to build a Desc per scrape.
+type configuredMetric struct {
+	cfg       pearlMetricConfig
+	labelSrcs []labelFileSource
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// configuredExtraFile is a pearlExtraFile entry that produces its own
+// metric (MetricName set); label-only entries are folded into
+// pearlCollector.extraFileLabels instead.
+type configuredExtraFile struct {
+	cfg       pearlExtraFile
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// buildConfiguredMetrics resolves a pearlConfig into the descriptors and
+// label orderings pearlCollector.Update needs, so that cardinality (the set
+// of metric names and label names) is fixed at start-up regardless of what
+// services later come and go.
+func buildConfiguredMetrics(cfg *pearlConfig) ([]configuredMetric, []labelFileSource, []configuredExtraFile) {
+	var extraFileLabels []labelFileSource
+	for _, ef := range cfg.ExtraFiles {
+		if ef.Label != "" {
+			extraFileLabels = append(extraFileLabels, labelFileSource{Label: ef.Label, File: ef.File})
+		}
+	}
+	sort.Slice(extraFileLabels, func(i, j int) bool { return extraFileLabels[i].Label < extraFileLabels[j].Label })
+
+	baseLabels := []string{"service", "channel_id"}
+	for _, src := range extraFileLabels {
+		baseLabels = append(baseLabels, src.Label)
+	}
+
+	metrics := make([]configuredMetric, 0, len(cfg.Metrics))
+	for _, m := range cfg.Metrics {
+		labelSrcs := sortedLabelFileSources(m.LabelsFromFile)
+
+		labelNames := make([]string, 0, len(baseLabels)+len(labelSrcs))
+		labelNames = append(labelNames, baseLabels...)
+		for _, src := range labelSrcs {
+			labelNames = append(labelNames, src.Label)
+		}
+
+		metrics = append(metrics, configuredMetric{
+			cfg:       m,
+			labelSrcs: labelSrcs,
+			desc:      prometheus.NewDesc(m.MetricName, m.Help, labelNames, nil),
+			valueType: valueTypeFor(m.Type),
+		})
+	}
+
+	extraFileMetrics := make([]configuredExtraFile, 0, len(cfg.ExtraFiles))
+	for _, ef := range cfg.ExtraFiles {
+		if ef.MetricName == "" {
+			continue
+		}
+		extraFileMetrics = append(extraFileMetrics, configuredExtraFile{
+			cfg:       ef,
+			desc:      prometheus.NewDesc(ef.MetricName, ef.Help, baseLabels, nil),
+			valueType: valueTypeFor(ef.Type),
+		})
+	}
+
+	return metrics, extraFileLabels, extraFileMetrics
+}
+
+func readServiceFile(svc RunitService, name string) string {
+	data, err := os.ReadFile(filepath.Join(svc.Path, svc.Name, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// getConfiguredStatusValue reads a single arbitrary field out of
+// /proc/<pid>/status, for metrics declared via --collector.pearl.config.
+func getConfiguredStatusValue(pid int, statusKey string) (int, bool, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, statusKey) {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return 0, false, fmt.Errorf("invalid value for %s: '%s'", statusKey, line)
+		}
+
+		value, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid value for %s: '%s'", statusKey, fields[1])
+		}
+
+		return value, true, nil
+	}
+
+	return 0, false, nil
+}
+
 func init() {
 	registerCollector("pearl", defaultEnabled, NewPearlCollector)
 }
 
+type cachedChannelID struct {
+	mtime time.Time
+	value string
+}
+
+// rateSample is the last observation fed into a rolling rate computation.
+type rateSample struct {
+	at    time.Time
+	total float64
+}
+
 type pearlCollector struct {
 	logger log.Logger
+
+	mtx           sync.Mutex
+	lastPid       map[string]int
+	restartCounts map[string]float64
+
+	servicesMtx sync.RWMutex
+	services    []RunitService
+
+	channelIDMtx   sync.Mutex
+	channelIDCache map[string]cachedChannelID
+
+	ctxtRateMtx    sync.Mutex
+	ctxtRateLast   map[string]rateSample
+	ctxtSwitchRate *prometheus.SummaryVec
+
+	configuredMetrics    []configuredMetric
+	extraFileLabels      []labelFileSource
+	configuredExtraFiles []configuredExtraFile
+
+	bootTimeOnce sync.Once
+	bootTime     int64
+	bootTimeErr  error
+
+	watcher *fsnotify.Watcher
+}
+
+// cachedBootTime returns the system boot time, reading it from /proc/stat
+// only on the first call since it never changes for the life of the process.
+func (c *pearlCollector) cachedBootTime() (int64, error) {
+	c.bootTimeOnce.Do(func() {
+		c.bootTime, c.bootTimeErr = getBootTime()
+	})
+	return c.bootTime, c.bootTimeErr
 }
 
 func NewPearlCollector(logger log.Logger) (Collector, error) {
-	return &pearlCollector{
-		logger: logger,
-	}, nil
+	c := &pearlCollector{
+		logger:         logger,
+		lastPid:        make(map[string]int),
+		restartCounts:  make(map[string]float64),
+		channelIDCache: make(map[string]cachedChannelID),
+		ctxtRateLast:   make(map[string]rateSample),
+		ctxtSwitchRate: prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "service_ctxt_switches_per_second",
+			Help:       "Rate of voluntary and nonvoluntary context switches per second for the service, sampled across scrapes",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, []string{"service", "channel_id"}),
+	}
+
+	if *pearlConfigPath != "" {
+		cfg, err := loadPearlConfig(*pearlConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pearl collector config %q: %w", *pearlConfigPath, err)
+		}
+
+		c.configuredMetrics, c.extraFileLabels, c.configuredExtraFiles = buildConfiguredMetrics(cfg)
+	}
+
+	if err := c.refreshServices(); err != nil {
+		logger.Log("msg", "initial pearl service discovery failed", "err", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		// Without a watcher the cached service list just relies on the
+		// periodic reconcile below; still usable, just less reactive.
+		logger.Log("msg", "failed to create fsnotify watcher for pearl services, falling back to periodic reconcile only", "err", err)
+	} else {
+		c.watcher = watcher
+
+		for _, root := range svcRoots {
+			if err := watcher.Add(root); err != nil {
+				logger.Log("msg", "failed to watch pearl service root", "root", root, "err", err)
+			}
+		}
+
+		go c.watchServices()
+	}
+
+	// watcher.Add only covers svcRoots themselves: a supervise directory
+	// appearing inside an already-existing /service/<name>/ (a new channel
+	// coming up without node_exporter restarting) fires no fsnotify event
+	// there. The periodic reconcile is the fallback the request allowed for
+	// exactly that case, and also keeps things correct if the watcher
+	// above couldn't be created at all.
+	go c.periodicReconcile()
+
+	return c, nil
 }
 
-func (c *pearlCollector) Update(ch chan<- prometheus.Metric) error {
+// pearlReconcileInterval is how often periodicReconcile re-walks svcRoots,
+// independently of fsnotify.
+const pearlReconcileInterval = 30 * time.Second
+
+func (c *pearlCollector) periodicReconcile() {
+	ticker := time.NewTicker(pearlReconcileInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.refreshServices(); err != nil {
+			c.logger.Log("msg", "periodic pearl service reconcile failed", "err", err)
+		}
+	}
+}
+
+// watchServices reconciles the cached service list whenever an entry is
+// created, removed or renamed directly under one of svcRoots, so Update
+// doesn't have to wait for the next periodicReconcile tick in the common
+// case.
+func (c *pearlCollector) watchServices() {
+	for {
+		select {
+		case event, ok := <-c.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := c.refreshServices(); err != nil {
+				c.logger.Log("msg", "failed to refresh pearl service list", "err", err)
+			}
+		case err, ok := <-c.watcher.Errors:
+			if !ok {
+				return
+			}
+			c.logger.Log("msg", "fsnotify watcher error for pearl services", "err", err)
+		}
+	}
+}
+
+func (c *pearlCollector) refreshServices() error {
 	services, err := getAllServices()
 	if err != nil {
 		return err
 	}
 
+	c.servicesMtx.Lock()
+	c.services = services
+	c.servicesMtx.Unlock()
+
+	return nil
+}
+
+func (c *pearlCollector) cachedServices() []RunitService {
+	c.servicesMtx.RLock()
+	defer c.servicesMtx.RUnlock()
+
+	services := make([]RunitService, len(c.services))
+	copy(services, c.services)
+
+	return services
+}
+
+// getLabels builds the service/channel_id label set for rs; the channel_id
+// file is only reread when its mtime changes.
+func (c *pearlCollector) getLabels(rs RunitService) map[string]string {
+	labels := make(map[string]string)
+	labels["service"] = strippedName(rs.Name)
+
+	if channelID := c.cachedChannelID(rs); channelID != "" {
+		labels["channel_id"] = channelID
+	}
+
+	return labels
+}
+
+func (c *pearlCollector) cachedChannelID(rs RunitService) string {
+	path := fmt.Sprintf("%s/%s/channel_id", rs.Path, rs.Name)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	c.channelIDMtx.Lock()
+	defer c.channelIDMtx.Unlock()
+
+	if cached, ok := c.channelIDCache[path]; ok && cached.mtime.Equal(info.ModTime()) {
+		return cached.value
+	}
+
+	value := rs.getChannelID()
+	c.channelIDCache[path] = cachedChannelID{mtime: info.ModTime(), value: value}
+
+	return value
+}
+
+// notePid records the current PID observed for a service and bumps its
+// restart counter when the PID has changed since the last scrape.
+func (c *pearlCollector) notePid(name string, pid int) float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	last, seen := c.lastPid[name]
+	c.lastPid[name] = pid
+	if seen && last != pid {
+		c.restartCounts[name]++
+	}
+
+	return c.restartCounts[name]
+}
+
+// observeCtxtSwitchRate feeds the rolling service_ctxt_switches_per_second
+// summary from the combined raw counter total, so a PID
+// recycle just drops one sample instead of reporting a bogus spike.
+func (c *pearlCollector) observeCtxtSwitchRate(name, serviceLabel, channelID string, total float64) {
+	c.ctxtRateMtx.Lock()
+	last, ok := c.ctxtRateLast[name]
+	now := time.Now()
+	c.ctxtRateLast[name] = rateSample{at: now, total: total}
+	c.ctxtRateMtx.Unlock()
+
+	if !ok {
+		return
+	}
+
+	elapsed := now.Sub(last.at).Seconds()
+	if elapsed <= 0 || total < last.total {
+		return
+	}
+
+	c.ctxtSwitchRate.WithLabelValues(serviceLabel, channelID).Observe((total - last.total) / elapsed)
+}
+
+func (c *pearlCollector) Update(ch chan<- prometheus.Metric) error {
+	services := c.cachedServices()
+
 	for _, svc := range services {
-		labels := svc.GetLabels()
-		metrics, err := svc.GetStatusFileMetrics()
+		labels := c.getLabels(svc)
+		status, err := svc.Status()
 		if err != nil {
-			c.logger.Log("msg", "failed to get metrics for service", "service", svc.Name, "err", err)
+			c.logger.Log("msg", "failed to get runit status for service", "service", svc.Name, "err", err)
 			continue
 		}
 
-		labelKeys := make([]string, 0, len(labels))
-		labelValues := make([]string, 0, len(labels))
-		for k, v := range labels {
-			labelKeys = append(labelKeys, k)
-			labelValues = append(labelValues, v)
+		metrics := map[string]int{}
+		if status.Pid != 0 {
+			metrics, err = getMetricsFromStatusFile(status.Pid)
+			if err != nil {
+				c.logger.Log("msg", "failed to get metrics for service", "service", svc.Name, "err", err)
+				continue
+			}
 		}
 
+		serviceName := strippedName(svc.Name)
+		channelID := labels["channel_id"]
+
+		// Fixed label set, matching the dedicated descriptors below: the
+		// legacy per-metric descs must also always carry channel_id (even
+		// when empty) so every sample in a family has the same cardinality.
+		labelKeys := []string{"service", "channel_id"}
+		labelValues := []string{serviceName, channelID}
+
 		for _, metric := range knownMetrics {
 			if value, ok := metrics[metric.MetricName]; ok {
 				ch <- prometheus.MustNewConstMetric(
@@ -92,11 +627,178 @@ func (c *pearlCollector) Update(ch chan<- prometheus.Metric) error {
 				)
 			}
 		}
+
+		restartCount := c.notePid(svc.Name, status.Pid)
+
+		// Exposed as-is from /proc/<pid>/status: a PID change resets the
+		// kernel's own counter to zero, so the exported value drops too.
+		// Prometheus' rate()/increase() already handle that as a counter
+		// reset; carrying a baseline across it would hide the reset instead.
+		rawCtxtSwitchTotal := 0.0
+		for _, metric := range ctxtSwitchMetrics {
+			if value, ok := metrics[metric.MetricName]; ok {
+				rawCtxtSwitchTotal += float64(value)
+				ch <- prometheus.MustNewConstMetric(
+					prometheus.NewDesc(metric.MetricName, metric.Description, labelKeys, nil),
+					prometheus.CounterValue,
+					float64(value),
+					labelValues...,
+				)
+			}
+		}
+		c.observeCtxtSwitchRate(svc.Name, serviceName, channelID, rawCtxtSwitchTotal)
+
+		up := 0.0
+		if status.NormallyUp && status.Pid != 0 {
+			up = 1.0
+		}
+
+		ch <- prometheus.MustNewConstMetric(serviceUpDesc, prometheus.GaugeValue, up, serviceName, channelID)
+		ch <- prometheus.MustNewConstMetric(serviceStateDesc, prometheus.GaugeValue, float64(status.State), serviceName, channelID)
+		ch <- prometheus.MustNewConstMetric(serviceUptimeSecondsDesc, prometheus.GaugeValue, float64(status.Duration), serviceName, channelID)
+		ch <- prometheus.MustNewConstMetric(serviceWantStateDesc, prometheus.GaugeValue, float64(status.Want), serviceName, channelID)
+		ch <- prometheus.MustNewConstMetric(serviceRestartCountDesc, prometheus.CounterValue, restartCount, serviceName, channelID)
+
+		if status.Pid == 0 {
+			continue
+		}
+
+		if stat, err := getProcStat(status.Pid); err != nil {
+			c.logger.Log("msg", "failed to get /proc/pid/stat for service", "service", svc.Name, "err", err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(serviceCPUSecondsTotalDesc, prometheus.CounterValue, float64(stat.UTicks)/clkTckHZ, serviceName, channelID, "user")
+			ch <- prometheus.MustNewConstMetric(serviceCPUSecondsTotalDesc, prometheus.CounterValue, float64(stat.STicks)/clkTckHZ, serviceName, channelID, "system")
+
+			if bootTime, err := c.cachedBootTime(); err != nil {
+				c.logger.Log("msg", "failed to get boot time", "err", err)
+			} else {
+				startTime := float64(bootTime) + float64(stat.StartTicks)/clkTckHZ
+				ch <- prometheus.MustNewConstMetric(serviceStartTimeSecondsDesc, prometheus.GaugeValue, startTime, serviceName, channelID)
+			}
+		}
+
+		if openFDs, err := getOpenFDCount(status.Pid); err != nil {
+			c.logger.Log("msg", "failed to get open FD count for service", "service", svc.Name, "err", err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(serviceOpenFDsDesc, prometheus.GaugeValue, float64(openFDs), serviceName, channelID)
+		}
+
+		if maxFDs, err := getMaxFDs(status.Pid); err != nil {
+			c.logger.Log("msg", "failed to get max FDs for service", "service", svc.Name, "err", err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(serviceMaxFDsDesc, prometheus.GaugeValue, float64(maxFDs), serviceName, channelID)
+		}
+
+		if *pearlPerThread {
+			c.updateThreadMetrics(ch, status.Pid, serviceName, channelID)
+		}
+
+		c.updateConfiguredMetrics(ch, svc, status.Pid, serviceName, channelID)
 	}
 
+	c.ctxtSwitchRate.Collect(ch)
+
 	return nil
 }
 
+// updateThreadMetrics emits per-thread CPU and context switch metrics for
+// pid, read from /proc/<pid>/task, plus a per-service sum of the
+// context-switch counts across all threads. The process-level CPU totals
+// emitted above already cover the aggregate, since /proc/<pid>/stat sums
+// utime/stime across the thread group, but /proc/<pid>/status only reports
+// ctxt switches for the thread-group leader, so the aggregate here is the
+// only place that covers all threads.
+func (c *pearlCollector) updateThreadMetrics(ch chan<- prometheus.Metric, pid int, serviceName, channelID string) {
+	tids, err := getThreadIDs(pid)
+	if err != nil {
+		c.logger.Log("msg", "failed to list threads for service", "service", serviceName, "err", err)
+		return
+	}
+
+	var voluntarySum, nonvoluntarySum float64
+
+	for _, tid := range tids {
+		tidLabel := strconv.Itoa(tid)
+
+		tcs, err := getThreadCtxtSwitches(pid, tid)
+		if err != nil {
+			c.logger.Log("msg", "failed to get thread status", "service", serviceName, "tid", tid, "err", err)
+			continue
+		}
+
+		stat, err := getThreadStat(pid, tid)
+		if err != nil {
+			c.logger.Log("msg", "failed to get thread stat", "service", serviceName, "tid", tid, "err", err)
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(serviceThreadCPUSecondsTotalDesc, prometheus.CounterValue, float64(stat.UTicks)/clkTckHZ, serviceName, channelID, tidLabel, tcs.Name, "user")
+		ch <- prometheus.MustNewConstMetric(serviceThreadCPUSecondsTotalDesc, prometheus.CounterValue, float64(stat.STicks)/clkTckHZ, serviceName, channelID, tidLabel, tcs.Name, "system")
+		ch <- prometheus.MustNewConstMetric(serviceThreadCtxtSwitchesDesc, prometheus.GaugeValue, float64(tcs.VoluntaryCtxtSwitches), serviceName, channelID, tidLabel, tcs.Name, "voluntary")
+		ch <- prometheus.MustNewConstMetric(serviceThreadCtxtSwitchesDesc, prometheus.GaugeValue, float64(tcs.NonvoluntaryCtxtSwitches), serviceName, channelID, tidLabel, tcs.Name, "nonvoluntary")
+
+		voluntarySum += float64(tcs.VoluntaryCtxtSwitches)
+		nonvoluntarySum += float64(tcs.NonvoluntaryCtxtSwitches)
+	}
+
+	ch <- prometheus.MustNewConstMetric(serviceThreadCtxtSwitchesSumDesc, prometheus.GaugeValue, voluntarySum, serviceName, channelID, "voluntary")
+	ch <- prometheus.MustNewConstMetric(serviceThreadCtxtSwitchesSumDesc, prometheus.GaugeValue, nonvoluntarySum, serviceName, channelID, "nonvoluntary")
+}
+
+// updateConfiguredMetrics emits the metrics declared via
+// --collector.pearl.config for a single service: the declarative
+// /proc/<pid>/status fields in configuredMetrics, and the extra_files
+// entries that produce their own metric rather than a label.
+func (c *pearlCollector) updateConfiguredMetrics(ch chan<- prometheus.Metric, svc RunitService, pid int, serviceName, channelID string) {
+	if len(c.configuredMetrics) == 0 && len(c.configuredExtraFiles) == 0 {
+		return
+	}
+
+	extraLabelValues := make([]string, len(c.extraFileLabels))
+	for i, src := range c.extraFileLabels {
+		extraLabelValues[i] = readServiceFile(svc, src.File)
+	}
+
+	for _, cm := range c.configuredMetrics {
+		value, found, err := getConfiguredStatusValue(pid, cm.cfg.StatusKey)
+		if err != nil {
+			c.logger.Log("msg", "failed to read configured pearl metric", "metric", cm.cfg.MetricName, "service", svc.Name, "err", err)
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		labelValues := make([]string, 0, 2+len(extraLabelValues)+len(cm.labelSrcs))
+		labelValues = append(labelValues, serviceName, channelID)
+		labelValues = append(labelValues, extraLabelValues...)
+		for _, src := range cm.labelSrcs {
+			labelValues = append(labelValues, readServiceFile(svc, src.File))
+		}
+
+		ch <- prometheus.MustNewConstMetric(cm.desc, cm.valueType, float64(value*cm.cfg.Multiplier), labelValues...)
+	}
+
+	for _, ef := range c.configuredExtraFiles {
+		raw := readServiceFile(svc, ef.cfg.File)
+		if raw == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			c.logger.Log("msg", "invalid numeric value in pearl extra_files entry", "file", ef.cfg.File, "service", svc.Name, "err", err)
+			continue
+		}
+
+		labelValues := make([]string, 0, 2+len(extraLabelValues))
+		labelValues = append(labelValues, serviceName, channelID)
+		labelValues = append(labelValues, extraLabelValues...)
+
+		ch <- prometheus.MustNewConstMetric(ef.desc, ef.valueType, value, labelValues...)
+	}
+}
+
 func isServiceDir(path string) (bool, error) {
 	if _, err := os.Stat(fmt.Sprintf(path + "/supervise")); err == nil {
 		return true, nil
@@ -133,15 +835,6 @@ func (rs *RunitService) getChannelID() string {
 	return strings.TrimSpace(string(buf[:n]))
 }
 
-func (rs *RunitService) GetStatusFileMetrics() (map[string]int, error) {
-	status, err := rs.Status()
-	if err != nil {
-		return nil, err
-	}
-
-	return getMetricsFromStatusFile(status.Pid)
-}
-
 func strippedName(name string) string {
 	if strings.Contains(name, ".") {
 		parts := strings.Split(name, ".")
@@ -151,17 +844,6 @@ func strippedName(name string) string {
 	return name
 }
 
-func (rs *RunitService) GetLabels() map[string]string {
-	labels := make(map[string]string)
-	labels["service"] = strippedName(rs.Name)
-
-	if channelID := rs.getChannelID(); channelID != "" {
-		labels["channel_id"] = channelID
-	}
-
-	return labels
-}
-
 func getMetricsFromStatusFile(pid int) (map[string]int, error) {
 	file := fmt.Sprintf("/proc/%d/status", pid)
 	f, err := os.Open(file)
@@ -190,12 +872,209 @@ func getMetricsFromStatusFile(pid int) (map[string]int, error) {
 				metrics[metric.MetricName] = value * metric.Multiplier
 			}
 		}
+
+		for _, metric := range ctxtSwitchMetrics {
+			if strings.HasPrefix(line, metric.StatusKey) {
+				parts := strings.Fields(line)
+				if len(parts) != metric.NumberOfParams+1 {
+					return nil, fmt.Errorf("invalid number of params for %s: '%s'", metric.StatusKey, line)
+				}
+
+				value, err := strconv.Atoi(parts[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for %s: '%s'", metric.StatusKey, parts[1])
+				}
+
+				metrics[metric.MetricName] = value
+			}
+		}
 	}
 
 	return metrics, nil
 
 }
 
+// procStat holds the fields of /proc/<pid>/stat needed for CPU and start
+// time accounting.
+type procStat struct {
+	UTicks     int64
+	STicks     int64
+	StartTicks int64
+}
+
+// getProcStat parses /proc/<pid>/stat. The comm field (2nd field) is
+// wrapped in parentheses and may itself contain spaces, so the fields
+// that matter here are located from the last ")" rather than by naive
+// whitespace splitting.
+func getProcStat(pid int) (*procStat, error) {
+	return parseStatFile(fmt.Sprintf("/proc/%d/stat", pid), pid)
+}
+
+// getThreadStat is the /proc/<pid>/task/<tid>/stat equivalent of
+// getProcStat, used for per-thread CPU accounting.
+func getThreadStat(pid, tid int) (*procStat, error) {
+	return parseStatFile(fmt.Sprintf("/proc/%d/task/%d/stat", pid, tid), tid)
+}
+
+func parseStatFile(path string, pid int) (*procStat, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	line := string(data)
+	idx := strings.LastIndex(line, ")")
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid stat line for pid %d", pid)
+	}
+
+	// fields[0] is the process state (field 3); utime, stime and starttime
+	// are fields 14, 15 and 22, i.e. indices 11, 12 and 19 here.
+	fields := strings.Fields(line[idx+1:])
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("too few fields in stat line for pid %d", pid)
+	}
+
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid utime for pid %d: %w", pid, err)
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stime for pid %d: %w", pid, err)
+	}
+	startTicks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid starttime for pid %d: %w", pid, err)
+	}
+
+	return &procStat{UTicks: utime, STicks: stime, StartTicks: startTicks}, nil
+}
+
+// getBootTime returns the system boot time as a unix timestamp, read from
+// the "btime" line of /proc/stat.
+func getBootTime() (int64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "btime") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("invalid btime line: '%s'", line)
+		}
+		return strconv.ParseInt(fields[1], 10, 64)
+	}
+
+	return 0, fmt.Errorf("btime not found in /proc/stat")
+}
+
+// getOpenFDCount returns the number of open file descriptors for pid, by
+// counting the entries in /proc/<pid>/fd.
+func getOpenFDCount(pid int) (int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+// getMaxFDs returns the soft limit on open file descriptors for pid, as
+// reported by /proc/<pid>/limits. It returns -1 if the limit is unlimited.
+func getMaxFDs(pid int) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/limits", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return 0, fmt.Errorf("invalid limits line: '%s'", line)
+		}
+
+		if fields[3] == "unlimited" {
+			return -1, nil
+		}
+
+		return strconv.Atoi(fields[3])
+	}
+
+	return 0, fmt.Errorf("Max open files not found in /proc/%d/limits", pid)
+}
+
+// threadCtxtSwitches holds the per-thread fields of /proc/<pid>/task/<tid>/status
+// that the generic knownMetrics parser doesn't give us a name for.
+type threadCtxtSwitches struct {
+	Name                     string
+	VoluntaryCtxtSwitches    int64
+	NonvoluntaryCtxtSwitches int64
+}
+
+func getThreadCtxtSwitches(pid, tid int) (*threadCtxtSwitches, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/status", pid, tid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tcs := &threadCtxtSwitches{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "Name:"):
+			tcs.Name = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+		case strings.HasPrefix(line, "voluntary_ctxt_switches:"):
+			fields := strings.Fields(line)
+			if len(fields) == 2 {
+				tcs.VoluntaryCtxtSwitches, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		case strings.HasPrefix(line, "nonvoluntary_ctxt_switches:"):
+			fields := strings.Fields(line)
+			if len(fields) == 2 {
+				tcs.NonvoluntaryCtxtSwitches, _ = strconv.ParseInt(fields[1], 10, 64)
+			}
+		}
+	}
+
+	return tcs, nil
+}
+
+// getThreadIDs lists the thread IDs of pid from /proc/<pid>/task.
+func getThreadIDs(pid int) ([]int, error) {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	tids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		tid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		tids = append(tids, tid)
+	}
+
+	return tids, nil
+}
+
 func getServicesInDir(dir string) ([]RunitService, error) {
 	var services []RunitService
 
@@ -221,9 +1100,12 @@ func getServicesInDir(dir string) ([]RunitService, error) {
 	return services, nil
 }
 
+// svcRoots are the directories walked for runit supervise trees, and the
+// roots watched for changes by pearlCollector's background reconciler.
+var svcRoots = []string{"/service/", "/tmp/service/"}
+
 func getAllServices() ([]RunitService, error) {
 	var services []RunitService
-	svcRoots := []string{"/service/", "/tmp/service/"}
 	for _, root := range svcRoots {
 		d, err := getServicesInDir(root)
 		if err != nil {